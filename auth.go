@@ -29,6 +29,7 @@ package mgo
 import (
 	"crypto/md5"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -83,19 +84,26 @@ type saslResult struct {
 	ErrMsg         string
 }
 
-type saslStepper interface {
+// SaslStepper drives one SASL-style authentication conversation. It's
+// the extension point Authenticator.NewConversation returns, so a
+// package outside mgo can implement a custom mechanism and register it
+// with RegisterAuthenticator.
+type SaslStepper interface {
 	Step(serverData []byte) (clientData []byte, done bool, err error)
 	Close()
 }
 
 func (socket *mongoSocket) Login(cred Credential) error {
 	socket.Lock()
-	// 默认使用 SCRAM-SHA-1 认证
+	// Auto-negotiating SCRAM-SHA-256 would need a connect-time hello
+	// asking the server for hello.saslSupportedMechs, which this series
+	// has no way to send; default to SHA-1 and let callers that know
+	// the server requires SHA-256 set Credential.Mechanism explicitly.
 	if cred.Mechanism == "" {
 		cred.Mechanism = "SCRAM-SHA-1"
 	}
 	for _, sockCred := range socket.creds {
-		if sockCred == cred {
+		if sockCred.equal(cred) {
 			debugf("Socket %p to %s: login: db=%q user=%q (already logged in)", socket, socket.addr, cred.Source, cred.Username)
 			socket.Unlock()
 			return nil
@@ -116,10 +124,12 @@ func (socket *mongoSocket) Login(cred Credential) error {
 	case "MONGODB-CR", "MONGO-CR":
 		// 不再支持旧的认证机制
 		err = fmt.Errorf("authentication mechanism %q is no longer supported, please use SCRAM-SHA-1 or SCRAM-SHA-256", cred.Mechanism)
-	case "PLAIN":
-		err = socket.loginPlain(cred)
 	case "MONGODB-X509":
 		err = socket.loginX509(cred)
+	case "MONGODB-OIDC":
+		err = socket.loginOIDC(cred)
+	case "MONGODB-AWS":
+		err = socket.loginAWS(cred)
 	default:
 		// 使用 SASL 进行认证
 		err = socket.loginSASL(cred)
@@ -153,35 +163,47 @@ func (socket *mongoSocket) loginX509(cred Credential) error {
 	})
 }
 
-func (socket *mongoSocket) loginPlain(cred Credential) error {
-	cmd := saslCmd{Start: 1, Mechanism: "PLAIN", Payload: []byte("\x00" + cred.Username + "\x00" + cred.Password)}
-	res := authResult{}
-	return socket.loginRun(cred.Source, &cmd, &res, func() error {
-		if !res.Ok {
-			return errors.New(res.ErrMsg)
-		}
-		socket.Lock()
-		socket.dropAuth(cred.Source)
-		socket.creds = append(socket.creds, cred)
-		socket.Unlock()
-		return nil
-	})
-}
-
+// loginSASL handles every mechanism that isn't special-cased directly in
+// Login: it looks the mechanism up in the Authenticator registry (which
+// covers SCRAM-SHA-1, SCRAM-SHA-256 and PLAIN by default, plus anything
+// added with RegisterAuthenticator) and only falls back to the CGO sasl
+// package, for mechanisms like GSSAPI/Kerberos, when nothing is
+// registered for it.
 func (socket *mongoSocket) loginSASL(cred Credential) error {
-	var sasl saslStepper
+	host := cred.ServiceHost
+	if host == "" {
+		host = socket.Server().Addr
+	}
+
+	var sasl SaslStepper
 	var err error
-	if cred.Mechanism == "SCRAM-SHA-1" {
-		// SCRAM is handled without external libraries.
-		sasl = saslNewScram(cred)
-	} else if len(cred.ServiceHost) > 0 {
-		sasl, err = saslNew(cred, cred.ServiceHost)
+	if a := lookupAuthenticator(cred.Mechanism); a != nil {
+		sasl, err = a.NewConversation(cred, host)
 	} else {
-		sasl, err = saslNew(cred, socket.Server().Addr)
+		sasl, err = saslNew(cred, host)
 	}
 	if err != nil {
 		return err
 	}
+	return socket.runSASL(cred, sasl)
+}
+
+// runSASL drives the saslStart/saslContinue round-trips for sasl against
+// the server, and records cred against the socket once the conversation
+// completes on both ends. It's shared by loginSASL and the mechanisms
+// with their own SaslStepper implementations, such as MONGODB-OIDC.
+func (socket *mongoSocket) runSASL(cred Credential, sasl SaslStepper) error {
+	return socket.runSASLFrom(cred, sasl, saslResult{}, true)
+}
+
+// runSASLFrom is runSASL generalized to resume a conversation that's
+// already past its first step: res carries the server's answer to that
+// step, and needStart is false so the next client message is sent as
+// saslContinue rather than repeating saslStart. runSASL is currently
+// its only caller, with needStart always true; the resume parameters
+// exist for a server-side-initiated first step such as a piggybacked
+// handshake, which this series doesn't have a call site for.
+func (socket *mongoSocket) runSASLFrom(cred Credential, sasl SaslStepper, res saslResult, needStart bool) error {
 	defer sasl.Close()
 
 	// The goal of this logic is to carry a locked socket until the
@@ -206,9 +228,11 @@ func (socket *mongoSocket) loginSASL(cred Credential) error {
 	lock(true)
 	defer lock(false)
 
-	start := 1
+	start := 0
+	if needStart {
+		start = 1
+	}
 	cmd := saslCmd{}
-	res := saslResult{}
 	for {
 		payload, done, err := sasl.Step(res.Payload)
 		if err != nil {
@@ -258,6 +282,24 @@ func saslNewScram(cred Credential) *saslScram {
 	return &saslScram{cred: cred, client: client}
 }
 
+// saslNewScramSHA256 builds the SCRAM-SHA-256 equivalent of saslNewScram.
+//
+// Unlike SCRAM-SHA-1, the SHA-256 mechanism doesn't use the legacy
+// md5(user:mongo:pass) password digest: the SASLprep'd password is fed
+// to the SCRAM client directly, which salts and hashes it itself.
+func saslNewScramSHA256(cred Credential) (*saslScram, error) {
+	user, err := saslPrep(cred.Username)
+	if err != nil {
+		return nil, fmt.Errorf("cannot SASLprep username: %v", err)
+	}
+	pass, err := saslPrep(cred.Password)
+	if err != nil {
+		return nil, fmt.Errorf("cannot SASLprep password: %v", err)
+	}
+	client := scram.NewClient(sha256.New, user, pass)
+	return &saslScram{cred: cred, client: client}, nil
+}
+
 type saslScram struct {
 	cred   Credential
 	client *scram.Client
@@ -353,9 +395,32 @@ func (socket *mongoSocket) dropAuth(db string) (cred Credential, found bool) {
 	return cred, false
 }
 
+// equal reports whether cred and other identify the same login, so that
+// a socket already authenticated as one doesn't need to repeat the
+// other. Credential carries fields that can't be compared with ==
+// (the OIDC callbacks and the AuthMechanismProperties map), so this
+// compares the fields that actually determine the authenticated
+// identity and ignores the ones that only configure how a token is
+// obtained. For MONGODB-OIDC, Username and Password are typically
+// empty and the identity is instead carried in the PRINCIPAL auth
+// mechanism property, so that's compared explicitly rather than
+// dropped along with the rest of AuthMechanismProperties.
+func (cred Credential) equal(other Credential) bool {
+	return cred.Source == other.Source &&
+		cred.Username == other.Username &&
+		cred.Password == other.Password &&
+		cred.Mechanism == other.Mechanism &&
+		cred.Service == other.Service &&
+		cred.ServiceHost == other.ServiceHost &&
+		cred.AWSAccessKeyID == other.AWSAccessKeyID &&
+		cred.AWSSecretAccessKey == other.AWSSecretAccessKey &&
+		cred.AWSSessionToken == other.AWSSessionToken &&
+		oidcPrincipal(cred) == oidcPrincipal(other)
+}
+
 func (socket *mongoSocket) dropLogout(cred Credential) (found bool) {
 	for i, sockCred := range socket.logout {
-		if sockCred == cred {
+		if sockCred.equal(cred) {
 			copy(socket.logout[i:], socket.logout[i+1:])
 			socket.logout = socket.logout[:len(socket.logout)-1]
 			return true
@@ -0,0 +1,445 @@
+// mgo - MongoDB driver for Go
+//
+// Copyright (c) 2010-2012 - Gustavo Niemeyer <gustavo@niemeyer.net>
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mgo
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CardInfoLink/mgo/bson"
+)
+
+// awsCredentials holds the access key, secret key and (for temporary
+// credentials) session token used to sign the GetCallerIdentity request
+// that backs MONGODB-AWS.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// awsCredCache avoids hitting IMDS (or the ECS/STS endpoints) for every
+// socket: resolved credentials are reused until they're within a minute
+// of expiring.
+//
+// The cache is process-wide rather than scoped to a session. That's a
+// deliberate narrowing, not an oversight: awsResolveCredentials never
+// puts explicit static credentials (cred.AWSAccessKeyID/SecretAccessKey/
+// SessionToken) in it, only the auto-resolved env/web-identity/ECS/IMDS
+// path below, and that path already resolves to the single IAM role
+// attached to the current host or container — every session in this
+// process would resolve to the same credentials anyway, cache or not.
+// A genuinely per-session cache would only matter once callers can
+// configure distinct AWS_* resolution per session, which Credential
+// doesn't support today.
+var (
+	awsCredMutex sync.Mutex
+	awsCredCache *awsCredentials
+)
+
+func awsResolveCredentials(cred Credential) (*awsCredentials, error) {
+	if cred.AWSAccessKeyID != "" || cred.AWSSecretAccessKey != "" || cred.AWSSessionToken != "" {
+		return &awsCredentials{
+			AccessKeyID:     cred.AWSAccessKeyID,
+			SecretAccessKey: cred.AWSSecretAccessKey,
+			SessionToken:    cred.AWSSessionToken,
+		}, nil
+	}
+
+	awsCredMutex.Lock()
+	cached := awsCredCache
+	awsCredMutex.Unlock()
+	if cached != nil && (cached.Expiration.IsZero() || cached.Expiration.After(time.Now().Add(time.Minute))) {
+		return cached, nil
+	}
+
+	creds, err := awsResolveFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	awsCredMutex.Lock()
+	awsCredCache = creds
+	awsCredMutex.Unlock()
+	return creds, nil
+}
+
+func awsResolveFromEnvironment() (*awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if path := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); path != "" {
+		return awsAssumeRoleWithWebIdentity(path)
+	}
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return awsFetchECSCredentials(uri)
+	}
+	return awsFetchEC2Credentials()
+}
+
+func awsAssumeRoleWithWebIdentity(tokenFile string) (*awsCredentials, error) {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-AWS: cannot read AWS_WEB_IDENTITY_TOKEN_FILE: %v", err)
+	}
+	roleArn := os.Getenv("AWS_ROLE_ARN")
+	if roleArn == "" {
+		return nil, errors.New("mgo: MONGODB-AWS: AWS_WEB_IDENTITY_TOKEN_FILE requires AWS_ROLE_ARN to be set")
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "mgo"
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := fmt.Sprintf(
+		"https://sts.%s.amazonaws.com/?Action=AssumeRoleWithWebIdentity&Version=2011-06-15&RoleArn=%s&RoleSessionName=%s&WebIdentityToken=%s",
+		region, url.QueryEscape(roleArn), url.QueryEscape(sessionName), url.QueryEscape(strings.TrimSpace(string(token))))
+
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-AWS: AssumeRoleWithWebIdentity request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mgo: MONGODB-AWS: AssumeRoleWithWebIdentity returned status %d", resp.StatusCode)
+	}
+
+	var out struct {
+		Result struct {
+			Credentials struct {
+				AccessKeyId     string `xml:"AccessKeyId"`
+				SecretAccessKey string `xml:"SecretAccessKey"`
+				SessionToken    string `xml:"SessionToken"`
+				Expiration      string `xml:"Expiration"`
+			} `xml:"Credentials"`
+		} `xml:"AssumeRoleWithWebIdentityResult"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-AWS: cannot decode AssumeRoleWithWebIdentity response: %v", err)
+	}
+	exp, _ := time.Parse(time.RFC3339, out.Result.Credentials.Expiration)
+	return &awsCredentials{
+		AccessKeyID:     out.Result.Credentials.AccessKeyId,
+		SecretAccessKey: out.Result.Credentials.SecretAccessKey,
+		SessionToken:    out.Result.Credentials.SessionToken,
+		Expiration:      exp,
+	}, nil
+}
+
+func awsFetchECSCredentials(relativeURI string) (*awsCredentials, error) {
+	resp, err := http.Get("http://169.254.170.2" + relativeURI)
+	if err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-AWS: ECS credentials request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mgo: MONGODB-AWS: ECS credentials endpoint returned status %d", resp.StatusCode)
+	}
+	var body struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+		Expiration      string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-AWS: cannot decode ECS credentials response: %v", err)
+	}
+	exp, _ := time.Parse(time.RFC3339, body.Expiration)
+	return &awsCredentials{
+		AccessKeyID:     body.AccessKeyId,
+		SecretAccessKey: body.SecretAccessKey,
+		SessionToken:    body.Token,
+		Expiration:      exp,
+	}, nil
+}
+
+func awsFetchEC2Credentials() (*awsCredentials, error) {
+	tokenReq, err := http.NewRequest("PUT", "http://169.254.169.254/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	tokenReq.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "30")
+	tokenResp, err := http.DefaultClient.Do(tokenReq)
+	if err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-AWS: IMDSv2 token request failed: %v", err)
+	}
+	tokenBytes, err := ioutil.ReadAll(tokenResp.Body)
+	tokenResp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	imdsToken := strings.TrimSpace(string(tokenBytes))
+
+	roleReq, err := http.NewRequest("GET", "http://169.254.169.254/latest/meta-data/iam/security-credentials/", nil)
+	if err != nil {
+		return nil, err
+	}
+	roleReq.Header.Set("X-aws-ec2-metadata-token", imdsToken)
+	roleResp, err := http.DefaultClient.Do(roleReq)
+	if err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-AWS: IMDSv2 role request failed: %v", err)
+	}
+	roleBytes, err := ioutil.ReadAll(roleResp.Body)
+	roleResp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	role := strings.TrimSpace(string(roleBytes))
+	if role == "" {
+		return nil, errors.New("mgo: MONGODB-AWS: no IAM role attached to this EC2 instance")
+	}
+
+	credReq, err := http.NewRequest("GET", "http://169.254.169.254/latest/meta-data/iam/security-credentials/"+role, nil)
+	if err != nil {
+		return nil, err
+	}
+	credReq.Header.Set("X-aws-ec2-metadata-token", imdsToken)
+	credResp, err := http.DefaultClient.Do(credReq)
+	if err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-AWS: IMDSv2 credentials request failed: %v", err)
+	}
+	defer credResp.Body.Close()
+	var body struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+		Expiration      string
+	}
+	if err := json.NewDecoder(credResp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-AWS: cannot decode IMDSv2 credentials response: %v", err)
+	}
+	exp, _ := time.Parse(time.RFC3339, body.Expiration)
+	return &awsCredentials{
+		AccessKeyID:     body.AccessKeyId,
+		SecretAccessKey: body.SecretAccessKey,
+		SessionToken:    body.Token,
+		Expiration:      exp,
+	}, nil
+}
+
+// awsValidateSTSHost guards against a malicious or misbehaving server
+// pointing the client at an arbitrary host to sign a request against.
+func awsValidateSTSHost(host string) error {
+	if host == "sts.amazonaws.com" {
+		return nil
+	}
+	if strings.HasPrefix(host, "sts.") && strings.HasSuffix(host, ".amazonaws.com") {
+		return nil
+	}
+	return fmt.Errorf("mgo: MONGODB-AWS: server returned untrusted STS host %q", host)
+}
+
+func awsRegionFromHost(host string) string {
+	if host == "sts.amazonaws.com" {
+		return "us-east-1"
+	}
+	if parts := strings.Split(host, "."); len(parts) >= 4 {
+		return parts[1]
+	}
+	return "us-east-1"
+}
+
+// awsSignSTSRequest produces the Authorization header and X-Amz-Date for
+// a SigV4-signed POST to https://<host>/ with the fixed
+// GetCallerIdentity body, per
+// https://docs.aws.amazon.com/general/latest/gr/sigv4-signed-request-examples.html.
+//
+// serverNonce is the 64-byte nonce the server returned in step 1 of the
+// MONGODB-AWS conversation (see awsSaslStepper.Step); it's bound into
+// the signature via the X-MongoDB-Server-Nonce header, alongside
+// X-MongoDB-GS2-CB-Flag, so the server can verify the signature was
+// computed for this conversation and not replayed from another one. A
+// nil/empty serverNonce omits both headers, which is only correct for
+// signing a bare GetCallerIdentity request outside of MONGODB-AWS.
+func awsSignSTSRequest(creds *awsCredentials, host, region string, serverNonce []byte, now time.Time) (authHeader, amzDate string) {
+	const service = "sts"
+	const body = "Action=GetCallerIdentity&Version=2011-06-15"
+
+	amzDate = now.UTC().Format("20060102T150405Z")
+	dateStamp := now.UTC().Format("20060102")
+
+	headers := map[string]string{
+		"content-length": strconv.Itoa(len(body)),
+		"content-type":   "application/x-www-form-urlencoded",
+		"host":           host,
+		"x-amz-date":     amzDate,
+	}
+	if creds.SessionToken != "" {
+		headers["x-amz-security-token"] = creds.SessionToken
+	}
+	if len(serverNonce) > 0 {
+		headers["x-mongodb-server-nonce"] = base64.StdEncoding.EncodeToString(serverNonce)
+		headers["x-mongodb-gs2-cb-flag"] = "n"
+	}
+	signedHeaders, canonicalHeaders := awsCanonicalHeaders(headers)
+
+	canonicalRequest := strings.Join([]string{
+		"POST",
+		"/",
+		"",
+		canonicalHeaders,
+		signedHeaders,
+		awsSHA256Hex([]byte(body)),
+	}, "\n")
+
+	credentialScope := dateStamp + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		credentialScope,
+		awsSHA256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := awsSigningKey(creds.SecretAccessKey, dateStamp, region, service)
+	signature := hex.EncodeToString(awsHMACSHA256(signingKey, stringToSign))
+
+	authHeader = fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, credentialScope, signedHeaders, signature)
+	return authHeader, amzDate
+}
+
+func awsCanonicalHeaders(headers map[string]string) (signedHeaders, canonical string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		buf.WriteString(name)
+		buf.WriteByte(':')
+		buf.WriteString(headers[name])
+		buf.WriteByte('\n')
+	}
+	return strings.Join(names, ";"), buf.String()
+}
+
+func awsSigningKey(secretAccessKey, dateStamp, region, service string) []byte {
+	kDate := awsHMACSHA256([]byte("AWS4"+secretAccessKey), dateStamp)
+	kRegion := awsHMACSHA256(kDate, region)
+	kService := awsHMACSHA256(kRegion, service)
+	return awsHMACSHA256(kService, "aws4_request")
+}
+
+func awsHMACSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func awsSHA256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// awsSaslStepper drives the two-step MONGODB-AWS conversation: a client
+// nonce and subprotocol byte, followed by a SigV4-signed
+// GetCallerIdentity request once the server names the STS host to sign
+// against.
+type awsSaslStepper struct {
+	cred        Credential
+	clientNonce []byte
+	step        int
+}
+
+func newAWSSaslStepper(cred Credential) (*awsSaslStepper, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-AWS: cannot generate client nonce: %v", err)
+	}
+	return &awsSaslStepper{cred: cred, clientNonce: nonce}, nil
+}
+
+func (s *awsSaslStepper) Step(serverData []byte) (clientData []byte, done bool, err error) {
+	switch s.step {
+	case 0:
+		s.step++
+		payload, err := bson.Marshal(bson.M{"r": s.clientNonce, "p": int32(110)})
+		return payload, false, err
+	case 1:
+		var reply struct {
+			ServerNonce []byte `bson:"s"`
+			Host        string `bson:"h"`
+		}
+		if err := bson.Unmarshal(serverData, &reply); err != nil {
+			return nil, false, fmt.Errorf("mgo: MONGODB-AWS: cannot unmarshal server reply: %v", err)
+		}
+		if len(reply.ServerNonce) != 64 || !bytes.Equal(reply.ServerNonce[:32], s.clientNonce) {
+			return nil, false, errors.New("mgo: MONGODB-AWS: server nonce does not extend the client nonce")
+		}
+		if err := awsValidateSTSHost(reply.Host); err != nil {
+			return nil, false, err
+		}
+
+		creds, err := awsResolveCredentials(s.cred)
+		if err != nil {
+			return nil, false, err
+		}
+		authHeader, amzDate := awsSignSTSRequest(creds, reply.Host, awsRegionFromHost(reply.Host), reply.ServerNonce, time.Now())
+
+		doc := bson.M{"a": authHeader, "d": amzDate}
+		if creds.SessionToken != "" {
+			doc["t"] = creds.SessionToken
+		}
+		s.step++
+		payload, err := bson.Marshal(doc)
+		return payload, true, err
+	default:
+		return nil, true, nil
+	}
+}
+
+func (s *awsSaslStepper) Close() {}
+
+func (socket *mongoSocket) loginAWS(cred Credential) error {
+	sasl, err := newAWSSaslStepper(cred)
+	if err != nil {
+		return err
+	}
+	return socket.runSASL(cred, sasl)
+}
@@ -0,0 +1,359 @@
+// mgo - MongoDB driver for Go
+//
+// Copyright (c) 2010-2012 - Gustavo Niemeyer <gustavo@niemeyer.net>
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mgo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CardInfoLink/mgo/bson"
+)
+
+// OIDCTokenInfo is the result of an OIDC callback or a built-in
+// ENVIRONMENT token provider: an access token plus the bookkeeping
+// needed to refresh or cache it.
+type OIDCTokenInfo struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// OIDCIdPInfo describes the identity provider returned by the server
+// during the first step of the MONGODB-OIDC human authentication flow.
+type OIDCIdPInfo struct {
+	Issuer        string   `bson:"issuer"`
+	ClientId      string   `bson:"clientId"`
+	RequestScopes []string `bson:"requestScopes"`
+}
+
+// OIDCMachineCallback mints an access token for an unattended (machine)
+// workload, such as a service account. It's invoked whenever there's no
+// cached token for cred's (address, principal) pair and cred has no
+// OIDCHumanCallback, which takes precedence when both are set.
+type OIDCMachineCallback func(cred Credential) (*OIDCTokenInfo, error)
+
+// OIDCHumanCallback mints an access token for an interactive (human)
+// user, given the identity provider information the server returned
+// for cred's principal.
+type OIDCHumanCallback func(cred Credential, idp *OIDCIdPInfo) (*OIDCTokenInfo, error)
+
+var oidcDefaultAllowedHosts = []string{
+	"*.mongodb.net",
+	"*.mongodb-dev.net",
+	"*.mongodbgov.net",
+	"localhost",
+	"127.0.0.1",
+	"::1",
+}
+
+type oidcCacheKey struct {
+	address   string
+	principal string
+}
+
+var (
+	oidcCacheMutex sync.Mutex
+	oidcCache      = make(map[oidcCacheKey]*OIDCTokenInfo)
+)
+
+func oidcCacheGet(key oidcCacheKey) *OIDCTokenInfo {
+	oidcCacheMutex.Lock()
+	defer oidcCacheMutex.Unlock()
+	token := oidcCache[key]
+	if token != nil && !token.ExpiresAt.IsZero() && !token.ExpiresAt.After(time.Now()) {
+		return nil
+	}
+	return token
+}
+
+func oidcCachePut(key oidcCacheKey, token *OIDCTokenInfo) {
+	oidcCacheMutex.Lock()
+	defer oidcCacheMutex.Unlock()
+	oidcCache[key] = token
+}
+
+func oidcCacheDelete(key oidcCacheKey) {
+	oidcCacheMutex.Lock()
+	defer oidcCacheMutex.Unlock()
+	delete(oidcCache, key)
+}
+
+// oidcValidateToken rejects a token callback result that can't be used
+// to step the conversation: a nil *OIDCTokenInfo, or one with no access
+// token, would otherwise be cached and handed to oidcStepper, which
+// panics dereferencing it.
+func oidcValidateToken(token *OIDCTokenInfo) error {
+	if token == nil || token.AccessToken == "" {
+		return errors.New("mgo: MONGODB-OIDC: callback returned no access token")
+	}
+	return nil
+}
+
+func oidcPrincipal(cred Credential) string {
+	principal, _ := cred.AuthMechanismProperties["PRINCIPAL"].(string)
+	return principal
+}
+
+func oidcAllowedHosts(cred Credential) []string {
+	raw, ok := cred.AuthMechanismProperties["ALLOWED_HOSTS"]
+	if !ok {
+		return oidcDefaultAllowedHosts
+	}
+	hosts, _ := raw.([]string)
+	return hosts
+}
+
+func oidcCheckAllowedHost(cred Credential, addr string) error {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	} else {
+		// addr had no port to split off (net.SplitHostPort also
+		// unwraps "[::1]" to "::1" when a port is present, so this
+		// branch only needs to strip the brackets from a port-less
+		// "[::1]"; a bare host passes through unchanged).
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	for _, pattern := range oidcAllowedHosts(cred) {
+		if pattern == host {
+			return nil
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("mgo: MONGODB-OIDC: host %q is not present in ALLOWED_HOSTS", host)
+}
+
+// oidcFetchToken resolves an access token from a built-in ENVIRONMENT
+// provider, for credentials that supply neither callback.
+func oidcFetchToken(cred Credential) (*OIDCTokenInfo, error) {
+	env, _ := cred.AuthMechanismProperties["ENVIRONMENT"].(string)
+	switch env {
+	case "azure":
+		return oidcFetchAzureToken(cred)
+	case "gcp":
+		return oidcFetchGCPToken(cred)
+	case "test":
+		return oidcFetchTestToken()
+	case "":
+		return nil, errors.New("mgo: MONGODB-OIDC requires an OIDCMachineCallback, OIDCHumanCallback, or an ENVIRONMENT auth mechanism property")
+	default:
+		return nil, fmt.Errorf("mgo: MONGODB-OIDC: unsupported ENVIRONMENT %q", env)
+	}
+}
+
+func oidcFetchAzureToken(cred Credential) (*OIDCTokenInfo, error) {
+	resource, _ := cred.AuthMechanismProperties["TOKEN_RESOURCE"].(string)
+	if resource == "" {
+		return nil, errors.New("mgo: MONGODB-OIDC: ENVIRONMENT=azure requires the TOKEN_RESOURCE auth mechanism property")
+	}
+	u := "http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=" + url.QueryEscape(resource)
+	if principal := oidcPrincipal(cred); principal != "" {
+		u += "&client_id=" + url.QueryEscape(principal)
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+	return oidcDoIMDSRequest(req)
+}
+
+func oidcFetchGCPToken(cred Credential) (*OIDCTokenInfo, error) {
+	resource, _ := cred.AuthMechanismProperties["TOKEN_RESOURCE"].(string)
+	if resource == "" {
+		return nil, errors.New("mgo: MONGODB-OIDC: ENVIRONMENT=gcp requires the TOKEN_RESOURCE auth mechanism property")
+	}
+	u := "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/identity?audience=" + url.QueryEscape(resource)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-OIDC: GCE metadata request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mgo: MONGODB-OIDC: GCE metadata endpoint returned status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// The GCE identity endpoint returns the JWT as a raw response body,
+	// not wrapped in JSON.
+	return &OIDCTokenInfo{AccessToken: strings.TrimSpace(string(body))}, nil
+}
+
+func oidcFetchTestToken() (*OIDCTokenInfo, error) {
+	path := os.Getenv("OIDC_TOKEN_FILE")
+	if path == "" {
+		return nil, errors.New("mgo: MONGODB-OIDC: ENVIRONMENT=test requires OIDC_TOKEN_FILE to be set")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-OIDC: cannot read OIDC_TOKEN_FILE: %v", err)
+	}
+	return &OIDCTokenInfo{AccessToken: strings.TrimSpace(string(data))}, nil
+}
+
+func oidcDoIMDSRequest(req *http.Request) (*OIDCTokenInfo, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-OIDC: token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mgo: MONGODB-OIDC: token endpoint returned status %d", resp.StatusCode)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   string `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("mgo: MONGODB-OIDC: cannot decode token response: %v", err)
+	}
+	info := &OIDCTokenInfo{AccessToken: body.AccessToken}
+	if secs, err := strconv.Atoi(body.ExpiresIn); err == nil {
+		info.ExpiresAt = time.Now().Add(time.Duration(secs) * time.Second)
+	}
+	return info, nil
+}
+
+// oidcStepper runs the one-step MONGODB-OIDC conversation used once an
+// access token is already available, whether freshly minted or served
+// from cache.
+type oidcStepper struct {
+	token *OIDCTokenInfo
+	done  bool
+}
+
+func (s *oidcStepper) Step(serverData []byte) (clientData []byte, done bool, err error) {
+	if s.done {
+		return nil, true, nil
+	}
+	s.done = true
+	payload, err := bson.Marshal(bson.M{"jwt": s.token.AccessToken})
+	if err != nil {
+		return nil, false, err
+	}
+	return payload, true, nil
+}
+
+func (s *oidcStepper) Close() {}
+
+// oidcHumanStepper runs the two-step MONGODB-OIDC conversation: the
+// client first asks for the principal's identity provider, then calls
+// the human callback with the server's answer to obtain a token.
+type oidcHumanStepper struct {
+	cred  Credential
+	addr  string
+	step  int
+	token *OIDCTokenInfo
+}
+
+func (s *oidcHumanStepper) Step(serverData []byte) (clientData []byte, done bool, err error) {
+	switch s.step {
+	case 0:
+		s.step++
+		payload, err := bson.Marshal(bson.M{"n": oidcPrincipal(s.cred)})
+		return payload, false, err
+	case 1:
+		var idp OIDCIdPInfo
+		if err := bson.Unmarshal(serverData, &idp); err != nil {
+			return nil, false, fmt.Errorf("mgo: MONGODB-OIDC: cannot unmarshal IdP info: %v", err)
+		}
+		token, err := s.cred.OIDCHumanCallback(s.cred, &idp)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := oidcValidateToken(token); err != nil {
+			return nil, false, err
+		}
+		s.token = token
+		oidcCachePut(oidcCacheKey{address: s.addr, principal: oidcPrincipal(s.cred)}, token)
+		s.step++
+		payload, err := bson.Marshal(bson.M{"jwt": token.AccessToken})
+		return payload, true, err
+	default:
+		return nil, true, nil
+	}
+}
+
+func (s *oidcHumanStepper) Close() {}
+
+func (socket *mongoSocket) loginOIDC(cred Credential) error {
+	addr := socket.Server().Addr
+	if err := oidcCheckAllowedHost(cred, addr); err != nil {
+		return err
+	}
+
+	key := oidcCacheKey{address: addr, principal: oidcPrincipal(cred)}
+	if token := oidcCacheGet(key); token != nil {
+		if err := socket.runSASL(cred, &oidcStepper{token: token}); err == nil {
+			return nil
+		}
+		// The cached token was rejected (for instance, revoked
+		// server-side); drop it and mint a fresh one below.
+		oidcCacheDelete(key)
+	}
+
+	switch {
+	case cred.OIDCHumanCallback != nil:
+		return socket.runSASL(cred, &oidcHumanStepper{cred: cred, addr: addr})
+	case cred.OIDCMachineCallback != nil:
+		token, err := cred.OIDCMachineCallback(cred)
+		if err != nil {
+			return err
+		}
+		if err := oidcValidateToken(token); err != nil {
+			return err
+		}
+		oidcCachePut(key, token)
+		return socket.runSASL(cred, &oidcStepper{token: token})
+	default:
+		token, err := oidcFetchToken(cred)
+		if err != nil {
+			return err
+		}
+		oidcCachePut(key, token)
+		return socket.runSASL(cred, &oidcStepper{token: token})
+	}
+}
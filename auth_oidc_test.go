@@ -0,0 +1,52 @@
+// mgo - MongoDB driver for Go
+//
+// Copyright (c) 2010-2012 - Gustavo Niemeyer <gustavo@niemeyer.net>
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mgo
+
+import "testing"
+
+func TestOIDCCheckAllowedHostIPv6(t *testing.T) {
+	cred := Credential{}
+
+	if err := oidcCheckAllowedHost(cred, "[::1]:27017"); err != nil {
+		t.Errorf("oidcCheckAllowedHost(%q): unexpected error: %v", "[::1]:27017", err)
+	}
+	if err := oidcCheckAllowedHost(cred, "evil.example.com:27017"); err == nil {
+		t.Error("oidcCheckAllowedHost: expected error for a disallowed host, got none")
+	}
+}
+
+func TestOIDCValidateToken(t *testing.T) {
+	if err := oidcValidateToken(nil); err == nil {
+		t.Error("oidcValidateToken(nil): expected error, got none")
+	}
+	if err := oidcValidateToken(&OIDCTokenInfo{}); err == nil {
+		t.Error("oidcValidateToken: expected error for an empty AccessToken, got none")
+	}
+	if err := oidcValidateToken(&OIDCTokenInfo{AccessToken: "token"}); err != nil {
+		t.Errorf("oidcValidateToken: unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// mgo - MongoDB driver for Go
+//
+// Copyright (c) 2010-2012 - Gustavo Niemeyer <gustavo@niemeyer.net>
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mgo
+
+// Credential holds the details to authenticate with a MongoDB server.
+type Credential struct {
+	// Username and Password hold the basic credential used in most
+	// mechanisms. Password isn't used for MONGODB-X509.
+	Username string
+	Password string
+
+	// Source is the database used to establish credentials and
+	// privileges with a MongoDB server. Defaults to the default
+	// database provided during dial, or "admin" if that was not set.
+	Source string
+
+	// Service defines the service name to use when authenticating with
+	// the GSSAPI mechanism. Defaults to "mongodb".
+	Service string
+
+	// ServiceHost defines which hostname to use when authenticating
+	// with the GSSAPI mechanism. If not specified, defaults to the
+	// MongoDB server's address.
+	ServiceHost string
+
+	// Mechanism defines the protocol for credential negotiation.
+	// Defaults to "SCRAM-SHA-1".
+	Mechanism string
+
+	// AuthMechanismProperties carries mechanism-specific options, such
+	// as MONGODB-OIDC's ENVIRONMENT, TOKEN_RESOURCE, ALLOWED_HOSTS and
+	// PRINCIPAL.
+	AuthMechanismProperties map[string]interface{}
+
+	// OIDCMachineCallback and OIDCHumanCallback supply access tokens for
+	// MONGODB-OIDC; see their doc comments for which flow each drives.
+	OIDCMachineCallback OIDCMachineCallback
+	OIDCHumanCallback   OIDCHumanCallback
+
+	// AWSAccessKeyID, AWSSecretAccessKey and AWSSessionToken carry
+	// explicit MONGODB-AWS credentials. Leave all three empty to resolve
+	// credentials automatically (environment, web identity, ECS, then
+	// EC2 IMDS), as the drivers do.
+	AWSAccessKeyID     string
+	AWSSecretAccessKey string
+	AWSSessionToken    string
+}
@@ -7,6 +7,6 @@ import (
 	"github.com/CardInfoLink/mgo/internal/sasl"
 )
 
-func saslNew(cred Credential, host string) (saslStepper, error) {
+func saslNew(cred Credential, host string) (SaslStepper, error) {
 	return sasl.New(cred.Username, cred.Password, cred.Mechanism, cred.Service, host)
 }
@@ -0,0 +1,77 @@
+// mgo - MongoDB driver for Go
+//
+// Copyright (c) 2010-2012 - Gustavo Niemeyer <gustavo@niemeyer.net>
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mgo
+
+import "testing"
+
+func TestSASLPrep(t *testing.T) {
+	tests := []struct {
+		in      string
+		out     string
+		wantErr bool
+	}{
+		{in: "user", out: "user"},
+		// U+00A0 (NO-BREAK SPACE) maps to U+0020 (SPACE) under SASLprep.
+		{in: "I X", out: "I X"},
+		// U+0007 (BEL) is a prohibited C0 control codepoint.
+		{in: "ab", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := saslPrep(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("saslPrep(%q): expected error, got none", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("saslPrep(%q): unexpected error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.out {
+			t.Errorf("saslPrep(%q) = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestSaslNewScramSHA256UsesSASLprep(t *testing.T) {
+	cred := Credential{Username: "user", Password: "pass"}
+	sasl, err := saslNewScramSHA256(cred)
+	if err != nil {
+		t.Fatalf("saslNewScramSHA256: %v", err)
+	}
+	if sasl == nil {
+		t.Fatal("saslNewScramSHA256 returned a nil stepper")
+	}
+
+	// A password with a codepoint SASLprep prohibits must be rejected,
+	// which only happens if the password goes through saslPrep.
+	cred = Credential{Username: "user", Password: "pa\u0007ss"}
+	if _, err := saslNewScramSHA256(cred); err == nil {
+		t.Error("saslNewScramSHA256: expected error for a prohibited codepoint in the password, got none")
+	}
+}